@@ -66,13 +66,13 @@ func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *f
 	// New node
 	node := &Object{name: req.Name, path: path, c: d.c, cs: d.cs}
 
-	err := SwiftConnection.ObjectPutBytes(node.c.Name, node.path, nil, "")
+	err := SwiftConnection.ObjectPutBytes(ctx, node.c.Name, node.path, nil, "")
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Get object handler
-	fh, err := node.open(req.Flags, &resp.Flags)
+	fh, err := node.open(ctx, req.Flags, &resp.Flags)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -89,6 +89,7 @@ func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *f
 
 	// Cache it
 	DirectoryCache.Set(d.c.Name, d.path, req.Name, node)
+	invalidateMetaCache(d.c.Name, d.path)
 
 	return node, fh, nil
 }
@@ -113,7 +114,7 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 
 	defer close(tasks)
 
-	// Cache check
+	// In-memory cache check
 	if _, nodes := DirectoryCache.GetAll(d.c.Name, d.path); nodes != nil {
 		for _, node := range nodes {
 			direntries = append(direntries, node.Export())
@@ -121,8 +122,27 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 		return direntries, nil
 	}
 
+	// Persistent cache check. This only saves the swift listing itself;
+	// nodes still get promoted into DirectoryCache below so Lookup and
+	// subsequent ReadDirAll calls hit the fast in-memory path.
+	var token string
+	if MetaStore != nil {
+		if token, err = containerValidityToken(ctx, d.c.Name); err == nil {
+			if cached, found := MetaStore.Lookup(d.c.Name, d.path, token); found {
+				children := make(map[string]Node, len(cached))
+				for _, entry := range cached {
+					node := entry.hydrate(d)
+					direntries = append(direntries, node.Export())
+					children[node.Name()] = node
+				}
+				DirectoryCache.AddAll(d.c.Name, d.path, d, children)
+				return direntries, nil
+			}
+		}
+	}
+
 	// Fetch objects
-	objects, err := SwiftConnection.ObjectsAll(d.c.Name, &swift.ObjectsOpts{
+	objects, err := SwiftConnection.ObjectsAll(ctx, d.c.Name, &swift.ObjectsOpts{
 		Delimiter: '/',
 		Prefix:    d.path,
 	})
@@ -141,6 +161,12 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 			fileName = strings.TrimSuffix(strings.TrimPrefix(o.Name, d.path), "/")
 		)
 
+		// Segments living under the in-container segments prefix are
+		// an implementation detail and must never surface to the user.
+		if fileName == SegmentsDirectory {
+			continue
+		}
+
 		// This is a symlink
 		if isSymlink(o, d.path) {
 			child = &Symlink{path: path, name: fileName, c: d.c, so: &o, sh: swift.Headers{}, p: d}
@@ -178,7 +204,14 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 				goto export
 			}
 
-			// Large objects needs extra information
+			// Large objects needs extra information. isLargeObject must
+			// recognize Static Large Objects (application/json content
+			// type plus X-Static-Large-Object: true) in addition to
+			// Dynamic ones, or a freshly listed SLO never gets the
+			// DirectoryLister.AddTask headers fetch that populates
+			// Object.segmented/sh, and is treated as an ordinary small
+			// file: its segments are never cleaned up by Remove/Setattr
+			// truncation, leaking storage.
 			if isLargeObject(&o) {
 				DirectoryLister.AddTask(child, tasks)
 				child = nil
@@ -218,6 +251,22 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 
 	DirectoryCache.AddAll(d.c.Name, d.path, d, children)
 
+	if MetaStore != nil && token != "" {
+		persisted := make([]metaCacheNode, 0, len(children))
+		complete := true
+		for _, node := range children {
+			entry, ok := toMetaCacheNode(node)
+			if !ok {
+				complete = false
+				break
+			}
+			persisted = append(persisted, entry)
+		}
+		if complete {
+			MetaStore.Store(d.c.Name, d.path, token, persisted)
+		}
+	}
+
 	return direntries, nil
 }
 
@@ -254,7 +303,7 @@ func (d *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node,
 	)
 
 	// Create the file in swift
-	if err := SwiftConnection.ObjectPutBytes(d.c.Name, absPath, nil, DirContentType); err != nil {
+	if err := SwiftConnection.ObjectPutBytes(ctx, d.c.Name, absPath, nil, DirContentType); err != nil {
 		return nil, fuse.EIO
 	}
 
@@ -274,6 +323,7 @@ func (d *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node,
 
 	// Cache eviction
 	DirectoryCache.Set(d.c.Name, d.path, req.Name, node)
+	invalidateMetaCache(d.c.Name, d.path)
 
 	return node, nil
 }
@@ -292,10 +342,10 @@ func (d *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	)
 
 	if directory, ok := node.(*Directory); ok {
-		return d.removeDirectory(directory, req.Name)
+		return d.removeDirectory(ctx, directory, req.Name)
 	}
 	if object, ok := node.(*Object); ok {
-		return d.removeObject(object, req.Name, path)
+		return d.removeObject(ctx, object, req.Name, path)
 	}
 
 	return fuse.ENOTSUP
@@ -305,26 +355,26 @@ func (d *Directory) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp
 	return nil
 }
 
-func (d *Directory) move(oldContainer, oldPath, oldName, newContainer, newPath, newName string) error {
+func (d *Directory) move(ctx context.Context, oldContainer, oldPath, oldName, newContainer, newPath, newName string) error {
 	// Get the old node from the cache
 	oldNode := DirectoryCache.Get(d.c.Name, d.path, oldName)
 
 	if oldObject, ok := oldNode.(*Object); ok {
 		// Move a manifest, not the aggregated result of its segments
 		if oldObject.segmented {
-			return d.moveManifest(oldContainer, oldPath, oldName, newContainer, newPath, newName, oldObject)
+			return d.moveManifest(ctx, oldContainer, oldPath, oldName, newContainer, newPath, newName, oldObject)
 		}
 		// Move a standard object
 		if !oldObject.segmented {
-			return d.moveObject(oldContainer, oldPath, oldName, newContainer, newPath, newName, oldObject)
+			return d.moveObject(ctx, oldContainer, oldPath, oldName, newContainer, newPath, newName, oldObject)
 		}
 	}
 
 	return fuse.ENOTSUP
 }
 
-func (d *Directory) moveObject(oldContainer, oldPath, oldName, newContainer, newPath, newName string, o *Object) error {
-	err := SwiftConnection.ObjectMove(oldContainer, oldPath+oldName, newContainer, newPath+newName)
+func (d *Directory) moveObject(ctx context.Context, oldContainer, oldPath, oldName, newContainer, newPath, newName string, o *Object) error {
+	err := SwiftConnection.ObjectMove(ctx, oldContainer, oldPath+oldName, newContainer, newPath+newName)
 	if err != nil {
 		return err
 	}
@@ -334,16 +384,32 @@ func (d *Directory) moveObject(oldContainer, oldPath, oldName, newContainer, new
 
 	DirectoryCache.Delete(oldContainer, oldPath, oldName)
 	DirectoryCache.Set(newContainer, newPath, newName, o)
+	invalidateMetaCache(oldContainer, oldPath)
+	invalidateMetaCache(newContainer, newPath)
 
 	return nil
 }
 
-func (d *Directory) moveManifest(oldContainer, oldPath, oldName, newContainer, newPath, newName string, o *Object) error {
-	_, err := SwiftConnection.ManifestCopy(oldContainer, oldPath+oldName, newContainer, newPath+newName, nil)
+func (d *Directory) moveManifest(ctx context.Context, oldContainer, oldPath, oldName, newContainer, newPath, newName string, o *Object) error {
+	var err error
+
+	if o.isStaticLargeObject() {
+		// Copy the manifest object itself instead of its concatenated
+		// content, so the segments it references stay untouched and
+		// are not duplicated.
+		_, err = SwiftConnection.ObjectCopy(
+			ctx,
+			oldContainer, oldPath+oldName+"?"+ManifestGetQuery,
+			newContainer, newPath+newName,
+			nil,
+		)
+	} else {
+		_, err = SwiftConnection.ManifestCopy(ctx, oldContainer, oldPath+oldName, newContainer, newPath+newName, nil)
+	}
 	if err != nil {
 		return err
 	}
-	err = SwiftConnection.ObjectDelete(oldContainer, oldPath+oldName)
+	err = SwiftConnection.ObjectDelete(ctx, oldContainer, oldPath+oldName)
 	if err != nil {
 		return err
 	}
@@ -353,38 +419,67 @@ func (d *Directory) moveManifest(oldContainer, oldPath, oldName, newContainer, n
 
 	DirectoryCache.Delete(oldContainer, oldPath, oldName)
 	DirectoryCache.Set(newContainer, newPath, newName, o)
+	invalidateMetaCache(oldContainer, oldPath)
+	invalidateMetaCache(newContainer, newPath)
 
 	return nil
 }
 
-func (d *Directory) removeDirectory(directory *Directory, name string) error {
-	SwiftConnection.ObjectDelete(directory.c.Name, directory.so.Name)
+func (d *Directory) removeDirectory(ctx context.Context, directory *Directory, name string) error {
+	SwiftConnection.ObjectDelete(ctx, directory.c.Name, directory.so.Name)
 	if _, found := DirectoryCache.Peek(directory.c.Name, directory.path); found {
 		DirectoryCache.DeleteAll(directory.c.Name, directory.path)
 	}
 
 	DirectoryCache.Delete(directory.c.Name, d.path, directory.name)
+	invalidateMetaCache(directory.c.Name, directory.path)
+	invalidateMetaCache(d.c.Name, d.path)
 
 	return nil
 }
 
-func (d *Directory) removeObject(object *Object, name, path string) error {
+func (d *Directory) removeObject(ctx context.Context, object *Object, name, path string) error {
 	if object.segmented {
-		_, h, err := SwiftConnection.Object(d.c.Name, path)
+		_, h, err := SwiftConnection.Object(ctx, d.c.Name, path)
 		if err != nil {
 			return err
 		}
-		if !SegmentPathRegex.Match([]byte(h[ManifestHeader])) {
+
+		// Static Large Objects carry their segment list in the manifest
+		// body rather than in a header: a single delete with
+		// multipart-manifest=delete removes the manifest and every
+		// segment it lists in one request.
+		if h[StaticManifestHeader] == "true" {
+			return d.removeObjectAndCache(ctx, d.c.Name, path+"?"+ManifestDeleteQuery, name)
+		}
+
+		// The manifest header spells out "container/prefix", and that
+		// container is where the segments actually live, whether that's
+		// d.cs (a dedicated "<container>_segments" container) or d.c
+		// itself (InContainerSegments' hidden .file-segments/ prefix).
+		// Trusting d.cs unconditionally looks in the wrong place
+		// whenever InContainerSegments is set.
+		manifest := h[ManifestHeader]
+		parts := SegmentPathRegex.FindStringSubmatch(manifest)
+		if parts == nil {
 			return fmt.Errorf("Invalid segment path for manifest %s", name)
 		}
-		if err := deleteSegments(d.cs.Name, h[ManifestHeader]); err != nil {
+		if err := deleteSegments(ctx, parts[1], manifest); err != nil {
 			return err
 		}
 	}
 
-	SwiftConnection.ObjectDelete(d.c.Name, path)
-	DirectoryCache.Delete(d.c.Name, d.path, name)
+	return d.removeObjectAndCache(ctx, d.c.Name, path, name)
+}
 
+// removeObjectAndCache deletes the swift object at path and evicts it
+// from the directory cache.
+func (d *Directory) removeObjectAndCache(ctx context.Context, container, path, name string) error {
+	if err := SwiftConnection.ObjectDelete(ctx, container, path); err != nil {
+		return err
+	}
+	DirectoryCache.Delete(d.c.Name, d.path, name)
+	invalidateMetaCache(d.c.Name, d.path)
 	return nil
 }
 
@@ -392,7 +487,7 @@ func (d *Directory) removeObject(object *Object, name, path string) error {
 // the cache.
 func (d *Directory) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
 	if t, ok := newDir.(*Directory); ok {
-		return d.move(d.c.Name, d.path, req.OldName, t.c.Name, t.path, req.NewName)
+		return d.move(ctx, d.c.Name, d.path, req.OldName, t.c.Name, t.path, req.NewName)
 	}
 	return fuse.ENOTSUP
 }
@@ -406,7 +501,7 @@ func (d *Directory) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.N
 	headers := map[string]string{ObjectSymlinkHeader: req.Target}
 
 	// Create the file in swift
-	w, err := SwiftConnection.ObjectCreate(d.c.Name, absPath, false, "", LinkContentType, headers)
+	w, err := SwiftConnection.ObjectCreate(ctx, d.c.Name, absPath, false, "", LinkContentType, headers)
 	if err != nil {
 		return nil, err
 	}
@@ -427,6 +522,7 @@ func (d *Directory) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.N
 	}
 
 	DirectoryCache.Set(d.c.Name, d.path, req.NewName, link)
+	invalidateMetaCache(d.c.Name, d.path)
 
 	return link, nil
 }
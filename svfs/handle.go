@@ -1,36 +1,314 @@
 package svfs
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/xlucas/swift"
 	"golang.org/x/net/context"
 )
 
+// OperationTimeout bounds how long each segment upload, segment
+// relocation and SLO manifest write issued through an ObjectHandle is
+// allowed to run, in addition to whatever deadline the calling FUSE
+// request already carries. Zero disables it. Truncation, the writer
+// opened for an unsegmented object, the segment scan done on append
+// and the final header updates run under the request or handle
+// context only.
+var OperationTimeout time.Duration
+
+// withTimeout derives a cancelable context from ctx, applying
+// OperationTimeout when it is set. The returned cancel func must
+// always be called to release resources.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, OperationTimeout)
+}
+
+// RootContext is the filesystem's top-level context. The code that
+// calls fs.Serve to start serving the mount is expected to replace it
+// with a context it cancels once, when the mount is torn down, so
+// every ObjectHandle's background context (see ObjectHandle.ctx) is
+// canceled along with it. Until that's wired up by the caller it
+// defaults to context.Background(), i.e. unmount does not interrupt
+// in-flight segment uploads. Note this only covers unmount: a Ctrl-C
+// or timeout on a single Read/Write syscall still does not cancel that
+// request's own segment uploads, since those run on the handle's
+// long-lived context rather than the short-lived one bazil/fuse hands
+// to each call.
+var RootContext = context.Background()
+
+// SegmentUploadConcurrency bounds how many segments an ObjectHandle
+// uploads to swift at once. Writes past the first segment no longer
+// block on the network round-trip of the previous one; they buffer
+// into memory and hand off to this pool instead.
+var SegmentUploadConcurrency uint = 4
+
+// randomSegmentID returns a short random hex string used to namespace
+// a segment prefix when segments live under SegmentsDirectory, where
+// the object's own path can't be reused without colliding with visible
+// directory names.
+func randomSegmentID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// etagCloser is implemented by segment writers that expose the ETag
+// returned by swift once the underlying PUT completes, so Static Large
+// Object manifests can reference segments precisely.
+type etagCloser interface {
+	io.Closer
+	ETag() string
+}
+
+// segmentJob is a buffered segment handed off to the upload pool.
+type segmentJob struct {
+	id   uint
+	data []byte
+}
+
+// segmentResult is reported by a worker once a segment PUT completes,
+// successfully or not.
+type segmentResult struct {
+	id   uint
+	etag string
+	size int64
+	err  error
+}
+
 // ObjectHandle represents an open object handle, similarly to
 // file handles.
 type ObjectHandle struct {
-	target        *Object
-	rd            io.ReadSeeker
-	wd            io.WriteCloser
-	create        bool
-	truncated     bool
-	nonce         string
-	wroteSegment  bool
-	segmentID     uint
-	uploaded      uint64
-	segmentPrefix string
-	segmentPath   string
+	// ctx/cancel scope background segment uploads, which outlive any
+	// single Write call. ctx is derived from RootContext, so it is
+	// canceled when the mount is torn down; it is NOT derived from the
+	// individual Read/Write/Release request contexts, so a per-request
+	// Ctrl-C or timeout does not by itself cancel this handle's
+	// in-flight segment uploads. Release's own defer fh.cancel() runs
+	// after wg.Wait() has already returned, so in practice this is only
+	// ever canceled by an actual unmount.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	target           *Object
+	rd               io.ReadSeeker
+	wd               io.WriteCloser
+	create           bool
+	truncated        bool
+	nonce            string
+	wroteSegment     bool
+	segmentID        uint
+	uploaded         uint64
+	segmentContainer string
+	segmentPrefix    string
+	segments         []sloSegment
+
+	// buf accumulates the segment currently being filled once writes
+	// have outgrown the single-object fast path. jobs/results/wg drive
+	// the bounded pool of concurrent segment uploads; errMu/firstErr
+	// propagate the first upload failure back to subsequent Writes.
+	buf      *bytes.Buffer
+	jobs     chan segmentJob
+	results  chan segmentResult
+	wg       sync.WaitGroup
+	errMu    sync.Mutex
+	firstErr error
+
+	// segmentNonces records, under Encryption, the nonce each segment
+	// path was encrypted with, keyed by segment path, so Release can
+	// persist them for newReader to decrypt each segment on its own.
+	segmentNonces map[string]string
+	nonceMu       sync.Mutex
+}
+
+// startWorkers launches the bounded segment upload pool and its result
+// collector. Called once, the first time a write overflows a segment.
+func (fh *ObjectHandle) startWorkers() {
+	n := SegmentUploadConcurrency
+	if n == 0 {
+		n = 1
+	}
+	fh.jobs = make(chan segmentJob, n)
+	fh.results = make(chan segmentResult, n)
+	for i := uint(0); i < n; i++ {
+		go fh.segmentWorker()
+	}
+	go fh.collectResults()
+}
+
+// segmentWorker uploads buffered segments handed to it over fh.jobs
+// until the channel is closed on Release, or fh.ctx is canceled by an
+// unmount.
+func (fh *ObjectHandle) segmentWorker() {
+	for job := range fh.jobs {
+		etag, err := fh.uploadSegment(fh.ctx, job.id, job.data)
+		fh.results <- segmentResult{id: job.id, etag: etag, size: int64(len(job.data)), err: err}
+		if fh.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// collectResults drains upload results, remembering the first error
+// and, in SLO mode, the per-segment metadata needed to build the
+// manifest once every upload has completed.
+func (fh *ObjectHandle) collectResults() {
+	for res := range fh.results {
+		if res.err != nil {
+			fh.setFirstError(res.err)
+		} else if SegmentMode == SLOMode {
+			fh.segments = append(fh.segments, sloSegment{
+				ID:        res.id,
+				Path:      fh.segmentContainer + "/" + segmentPath(fh.segmentPrefix, &res.id),
+				ETag:      res.etag,
+				SizeBytes: res.size,
+			})
+		}
+		fh.wg.Done()
+	}
+}
+
+// uploadSegment PUTs one already-assembled segment to swift. It touches
+// no shared mutable state besides the network call itself and, under
+// Encryption, the nonce it records for its own segment path, so it is
+// safe to call from multiple workers at once.
+func (fh *ObjectHandle) uploadSegment(ctx context.Context, id uint, data []byte) (etag string, err error) {
+	opCtx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	path := segmentPath(fh.segmentPrefix, &id)
+
+	if Encryption {
+		// Each segment gets its own nonce rather than sharing the
+		// handle's, since newReader decrypts segments independently
+		// and concurrent uploads rule out a single running cipher
+		// stream across them.
+		var nonce string
+		w, werr := newWriter(opCtx, fh.segmentContainer, path, &nonce)
+		if werr != nil {
+			return "", werr
+		}
+		if _, werr = w.Write(data); werr != nil {
+			w.Close()
+			return "", werr
+		}
+		if werr = w.Close(); werr != nil {
+			return "", werr
+		}
+		fh.recordSegmentNonce(path, nonce)
+	} else if err = SwiftConnection.ObjectPutBytes(opCtx, fh.segmentContainer, path, data, ObjContentType); err != nil {
+		return "", err
+	}
+
+	_, h, err := SwiftConnection.Object(opCtx, fh.segmentContainer, path)
+	if err != nil {
+		return "", err
+	}
+	return h["Etag"], nil
+}
+
+// recordSegmentNonce remembers the nonce a segment was encrypted with.
+func (fh *ObjectHandle) recordSegmentNonce(path, nonce string) {
+	fh.nonceMu.Lock()
+	if fh.segmentNonces == nil {
+		fh.segmentNonces = map[string]string{}
+	}
+	fh.segmentNonces[path] = nonce
+	fh.nonceMu.Unlock()
+}
+
+// updateSegmentNonces persists the nonce each segment of target was
+// encrypted with as a single JSON-encoded header on the manifest
+// object, so newReader can decrypt every segment independently
+// without a per-segment metadata fetch.
+func updateSegmentNonces(ctx context.Context, target *Object, nonces map[string]string) error {
+	body, err := json.Marshal(nonces)
+	if err != nil {
+		return err
+	}
+
+	h := target.sh.ObjectMetadata().Headers(ObjectMetaHeader)
+	target.sh[SegmentNoncesHeader] = string(body)
+	h[SegmentNoncesHeader] = target.sh[SegmentNoncesHeader]
+
+	return SwiftConnection.ObjectUpdate(ctx, target.c.Name, target.so.Name, h)
+}
+
+// submitSegment hands a filled segment buffer off to the upload pool
+// and assigns it the next segment ID.
+func (fh *ObjectHandle) submitSegment(data []byte) {
+	id := fh.segmentID
+	fh.segmentID++
+	owned := make([]byte, len(data))
+	copy(owned, data)
+	fh.wg.Add(1)
+	fh.jobs <- segmentJob{id: id, data: owned}
+}
+
+// setFirstError remembers the first upload failure seen by any worker.
+func (fh *ObjectHandle) setFirstError(err error) {
+	fh.errMu.Lock()
+	if fh.firstErr == nil {
+		fh.firstErr = err
+	}
+	fh.errMu.Unlock()
+}
+
+// checkWorkerError reports whether a prior segment upload has already
+// failed, so Write can stop accepting data for this handle.
+func (fh *ObjectHandle) checkWorkerError() error {
+	fh.errMu.Lock()
+	defer fh.errMu.Unlock()
+	if fh.firstErr != nil {
+		return fuse.EIO
+	}
+	return nil
+}
+
+// putSLOManifest writes the JSON manifest describing every segment
+// uploaded through this handle, finalizing the object as a Static
+// Large Object. Segments are sorted by ID first since the upload pool
+// completes them out of order.
+func (fh *ObjectHandle) putSLOManifest(ctx context.Context) error {
+	opCtx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	sort.Slice(fh.segments, func(i, j int) bool { return fh.segments[i].ID < fh.segments[j].ID })
+	body, err := json.Marshal(fh.segments)
+	if err != nil {
+		return err
+	}
+	return SwiftConnection.ObjectPutBytes(
+		opCtx,
+		fh.target.c.Name,
+		fh.target.path+"?"+ManifestPutQuery,
+		body,
+		ManifestContentType,
+	)
 }
 
 // Read gets a swift object data for a request within the current context.
 // The request size is always honored. We open the file on the first write.
 func (fh *ObjectHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if fh.rd == nil {
-		fh.rd, err = newReader(fh)
+		fh.rd, err = newReader(ctx, fh)
 		if err != nil {
 			return err
 		}
@@ -41,17 +319,59 @@ func (fh *ObjectHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *f
 	return nil
 }
 
-// Release frees the file handle, closing all readers/writers in use.
+// Release frees the file handle, closing all readers/writers in use
+// and, for segmented objects, waiting on every in-flight segment
+// upload before assembling the manifest.
 func (fh *ObjectHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	if fh.cancel != nil {
+		defer fh.cancel()
+	}
 	if fh.rd != nil {
 		if closer, ok := fh.rd.(io.Closer); ok {
 			closer.Close()
 		}
 	}
-	if fh.wd != nil {
-		fh.wd.Close()
-		if Encryption {
-			if err := updateHeaders(fh.target, fh.nonce); err != nil {
+	if fh.wd != nil || fh.wroteSegment {
+		if fh.wroteSegment {
+			// Flush the last, possibly partial, segment still sitting
+			// in the buffer, then wait for the whole pool to drain. The
+			// uploads and the manifest and nonce writes below run on the
+			// handle's own context, not ctx, so canceling this Release
+			// request does not abort them.
+			if fh.buf != nil && fh.buf.Len() > 0 {
+				fh.submitSegment(fh.buf.Bytes())
+				fh.buf = nil
+			}
+			close(fh.jobs)
+			fh.wg.Wait()
+			close(fh.results)
+			if err := fh.checkWorkerError(); err != nil {
+				return err
+			}
+			if SegmentMode == SLOMode {
+				if err := fh.putSLOManifest(fh.ctx); err != nil {
+					return err
+				}
+				// Mark the node as the SLO it now is, so a later
+				// truncate or removeSegments takes the SLO branch.
+				fh.target.so.ContentType = ManifestContentType
+				fh.target.sh[StaticManifestHeader] = "true"
+				fh.target.slo = fh.segments
+			}
+			if Encryption && len(fh.segmentNonces) > 0 {
+				if err := updateSegmentNonces(fh.ctx, fh.target, fh.segmentNonces); err != nil {
+					return err
+				}
+			}
+		}
+		if fh.wd != nil {
+			fh.wd.Close()
+		}
+		// fh.nonce only covers the single writer used by the
+		// unsegmented fast path and the first relocated segment;
+		// segments written after that record their own nonce above.
+		if Encryption && !fh.wroteSegment {
+			if err := updateHeaders(ctx, fh.target, fh.nonce); err != nil {
 				return err
 			}
 		}
@@ -68,24 +388,28 @@ func (fh *ObjectHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) e
 // If we detect that we are writing more data than the configured
 // segment size, then the first object we were writing to is moved
 // to the segment container and named accordingly to DLO conventions.
-// Remaining data will be split into segments sequentially until
-// file handle release is called. If we are overwriting an object
-// we handle segment deletion, and object creation.
+// Remaining data is buffered into segment-sized chunks and handed off
+// to a bounded pool of SegmentUploadConcurrency workers, so a Write
+// does not wait on the upload of the segment it fills unless every
+// worker is busy and the job queue is full. If we are overwriting an
+// object we handle segment deletion, and object creation.
 func (fh *ObjectHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	// Make sure no lock can be acquired without releasing this filehandle.
 	fh.target.writing = true
 
 	// Truncate the file if not freshly created.
 	if !fh.create && !fh.truncated {
-		if err := fh.truncate(); err != nil {
+		if err := fh.truncate(ctx); err != nil {
 			return err
 		}
 	}
 
-	// Write first segment or file with size smaller than a segment size
-	if fh.uploaded+uint64(len(req.Data)) <= uint64(SegmentSize) {
-		// File size is less than the size of a segment or we didn't fill
-		// the current segment yet.
+	// Fast path: the object still fits in a single, unsegmented write.
+	if !fh.wroteSegment && fh.uploaded+uint64(len(req.Data)) <= uint64(SegmentSize) {
 		if _, err := fh.wd.Write(req.Data); err != nil {
 			return err
 		}
@@ -93,69 +417,236 @@ func (fh *ObjectHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp
 		fh.uploaded += uint64(len(req.Data))
 		fh.target.so.Bytes += int64(len(req.Data))
 
-		goto EndWrite
+		resp.Size = len(req.Data)
+		return nil
 	}
 
-	// File size is greater than the size of a segment
-	if fh.uploaded+uint64(len(req.Data)) > uint64(SegmentSize) {
-		// Create first segment from current object
-		if !fh.wroteSegment {
-			if err := fh.moveToSegment(); err != nil {
-				return err
-			}
-		}
-		// Open next segment
-		fh.wd.Close()
-		fh.wd, err = initSegment(fh.target.cs.Name, fh.segmentPrefix, &fh.segmentID, fh.target.so, req.Data, &fh.uploaded, &fh.nonce)
-		if err != nil {
+	// Create first segment from current object and start the pool.
+	if !fh.wroteSegment {
+		if err := fh.moveToSegment(ctx); err != nil {
 			return err
 		}
+		fh.startWorkers()
+		fh.buf = bytes.NewBuffer(make([]byte, 0, SegmentSize))
+	}
+
+	if err := fh.checkWorkerError(); err != nil {
+		return err
+	}
+
+	for data := req.Data; len(data) > 0; {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		room := int64(SegmentSize) - int64(fh.buf.Len())
+		n := int64(len(data))
+		if n > room {
+			n = room
+		}
+		fh.buf.Write(data[:n])
+		data = data[n:]
 
-		goto EndWrite
+		fh.uploaded += uint64(n)
+		fh.target.so.Bytes += n
+
+		if int64(fh.buf.Len()) == int64(SegmentSize) {
+			fh.submitSegment(fh.buf.Bytes())
+			fh.buf = bytes.NewBuffer(make([]byte, 0, SegmentSize))
+		}
 	}
 
-EndWrite:
 	resp.Size = len(req.Data)
 	return nil
 }
 
-func (fh *ObjectHandle) moveToSegment() error {
-	// Close previous writer.
-	fh.wd.Close()
+func (fh *ObjectHandle) moveToSegment(ctx context.Context) error {
+	opCtx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	// Get the next segment name and path
-	fh.segmentPrefix = fmt.Sprintf("%s/%d", fh.target.path, time.Now().Unix())
-	fh.segmentPath = segmentPath(fh.segmentPrefix, &fh.segmentID)
+	// Close the previous writer, if Write had one open. prepareAppend
+	// promotes an object to segmented without ever opening one.
+	if fh.wd != nil {
+		fh.wd.Close()
+	}
+
+	// Get the next segment container and name. Providers that reject
+	// a dedicated "<container>_segments" container get their segments
+	// tucked under a hidden, randomly named prefix of the manifest's
+	// own container instead of one derived from the object's path.
+	if InContainerSegments {
+		fh.segmentContainer = fh.target.c.Name
+		fh.segmentPrefix = SegmentsDirectory + "/" + randomSegmentID()
+	} else {
+		fh.segmentContainer = fh.target.cs.Name
+		fh.segmentPrefix = fmt.Sprintf("%s/%d", fh.target.path, time.Now().Unix())
+	}
 
 	// Move data to segment container
-	err := SwiftConnection.ObjectMove(fh.target.c.Name, fh.target.path, fh.target.cs.Name, fh.segmentPath)
+	firstSegment := segmentPath(fh.segmentPrefix, &fh.segmentID)
+	err := SwiftConnection.ObjectMove(opCtx, fh.target.c.Name, fh.target.path, fh.segmentContainer, firstSegment)
 	if err != nil {
 		return err
 	}
+	fh.segmentID++
+
+	// The relocated body is still encrypted under fh.nonce, the nonce
+	// the original writer used; record it under its new segment path
+	// so it rides along with segments 1+ into updateSegmentNonces
+	// instead of being dropped on the floor.
+	// When prepareAppend promotes an object, no writer was opened on
+	// this handle, so the body's nonce is the one stored on the object.
+	if Encryption {
+		nonce := fh.nonce
+		if nonce == "" {
+			nonce = fh.target.sh[ObjectNonceHeader]
+		}
+		fh.recordSegmentNonce(firstSegment, nonce)
+	}
 
-	// Create the manifest
-	createManifest(fh.target, fh.target.c.Name, fh.target.cs.Name+"/"+fh.segmentPrefix, fh.target.path)
+	if SegmentMode == SLOMode {
+		// so.Hash is never populated for a node built by Directory.Create
+		// or left over from before a truncate, so segment 0's real ETag
+		// has to be fetched the same way segments 1+ already do in
+		// uploadSegment, rather than trusted off the stale/empty field.
+		_, h, err := SwiftConnection.Object(opCtx, fh.segmentContainer, firstSegment)
+		if err != nil {
+			return err
+		}
+		fh.segments = append(fh.segments, sloSegment{
+			ID:        0,
+			Path:      fh.segmentContainer + "/" + firstSegment,
+			ETag:      h["Etag"],
+			SizeBytes: int64(fh.uploaded),
+		})
+	} else {
+		// Dynamic Large Objects only need the manifest header, which can
+		// be set as soon as the prefix is known.
+		createManifest(opCtx, fh.target, fh.target.c.Name, fh.segmentContainer+"/"+fh.segmentPrefix, fh.target.path)
+	}
 	fh.wroteSegment = true
 	fh.target.segmented = true
+	// Further writes go through the buffered segment pool, not fh.wd.
+	fh.wd = nil
 
 	return err
 }
 
-func (fh *ObjectHandle) truncate() (err error) {
-	// Remove referenced segments
-	if fh.target.segmented {
-		err = deleteSegments(fh.target.cs.Name, fh.target.sh[ManifestHeader])
+// prepareAppend readies oh so that Write only appends new segments to
+// an existing Dynamic Large Object instead of truncating it. A plain,
+// unsegmented object is first promoted exactly as a normal overflowing
+// Write would, relocating its current body to the first segment; an
+// already-segmented object just resumes at the next free segment ID
+// under its existing manifest prefix. Static Large Objects are not
+// supported since their manifest would need a full rewrite to append
+// one more segment, and neither is any append while SegmentMode is
+// SLOMode: both return ENOTSUP.
+func (fh *ObjectHandle) prepareAppend(ctx context.Context) error {
+	if fh.target.isStaticLargeObject() || SegmentMode == SLOMode {
+		return fuse.ENOTSUP
+	}
+
+	fh.truncated = true
+	fh.wroteSegment = true
+	fh.uploaded = uint64(fh.target.so.Bytes)
+
+	if !fh.target.segmented {
+		if err := fh.moveToSegment(ctx); err != nil {
+			return err
+		}
+	} else {
+		parts := SegmentPathRegex.FindStringSubmatch(fh.target.sh[ManifestHeader])
+		if parts == nil {
+			return fmt.Errorf("invalid segment path for manifest %s", fh.target.path)
+		}
+		fh.segmentContainer = parts[1]
+		fh.segmentPrefix = strings.TrimSuffix(parts[2], "/")
+
+		id, err := nextSegmentID(ctx, fh.segmentContainer, fh.segmentPrefix)
 		if err != nil {
 			return err
 		}
-		delete(fh.target.sh, ManifestHeader)
-		fh.target.segmented = false
+		fh.segmentID = id
+
+		// Keep the nonces of the segments already there, since Release
+		// rewrites the whole nonce header.
+		if Encryption && fh.target.sh[SegmentNoncesHeader] != "" {
+			if err := json.Unmarshal([]byte(fh.target.sh[SegmentNoncesHeader]), &fh.segmentNonces); err != nil {
+				return err
+			}
+		}
+	}
+
+	fh.startWorkers()
+	fh.buf = bytes.NewBuffer(make([]byte, 0, SegmentSize))
+
+	return nil
+}
+
+// nextSegmentID scans the segments already stored at container/prefix
+// and returns one past the highest index found, so an append never
+// collides with a segment uploaded by a previous Write pass.
+func nextSegmentID(ctx context.Context, container, prefix string) (uint, error) {
+	objects, err := SwiftConnection.ObjectsAll(ctx, container, &swift.ObjectsOpts{Prefix: prefix + "/"})
+	if err != nil {
+		return 0, err
+	}
+
+	var next uint
+	for _, o := range objects {
+		id, err := strconv.ParseUint(strings.TrimPrefix(o.Name, prefix+"/"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if uint(id)+1 > next {
+			next = uint(id) + 1
+		}
+	}
+
+	return next, nil
+}
+
+func (fh *ObjectHandle) truncate(ctx context.Context) (err error) {
+	// Remove referenced segments
+	if fh.target.segmented {
+		// Static Large Objects never set X-Object-Manifest, so they need
+		// their own multipart-manifest=delete branch here, the same one
+		// Object.removeSegments and Directory.removeObject already got:
+		// falling through to the DLO path below would always find an
+		// empty manifest header and fail every overwrite of an existing
+		// SLO file.
+		if fh.target.isStaticLargeObject() {
+			if err = SwiftConnection.ObjectDelete(ctx, fh.target.c.Name, fh.target.path+"?"+ManifestDeleteQuery); err != nil {
+				return err
+			}
+			fh.target.slo = nil
+			delete(fh.target.sh, StaticManifestHeader)
+			fh.target.segmented = false
+		} else {
+			// The manifest header spells out "container/prefix", and that
+			// container is where the segments actually live, whether
+			// that's fh.target.cs (a dedicated "<container>_segments"
+			// container) or fh.target.c itself (InContainerSegments'
+			// hidden .file-segments/ prefix). Trusting fh.target.cs
+			// unconditionally looks in the wrong place whenever
+			// InContainerSegments is set.
+			manifest := fh.target.sh[ManifestHeader]
+			parts := SegmentPathRegex.FindStringSubmatch(manifest)
+			if parts == nil {
+				return fmt.Errorf("invalid segment path for manifest %s", fh.target.path)
+			}
+			err = deleteSegments(ctx, parts[1], manifest)
+			if err != nil {
+				return err
+			}
+			delete(fh.target.sh, ManifestHeader)
+			fh.target.segmented = false
+		}
 	}
 
 	// Reopen for writing
 	fh.truncated = true
 	fh.target.so.Bytes = 0
-	fh.wd, err = newWriter(fh.target.c.Name, fh.target.so.Name, &fh.nonce)
+	fh.wd, err = newWriter(ctx, fh.target.c.Name, fh.target.so.Name, &fh.nonce)
 
 	return err
 }
@@ -0,0 +1,313 @@
+package svfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/xlucas/swift"
+	"golang.org/x/net/context"
+)
+
+// MetaCacheDir is the filesystem path of the persistent metadata cache.
+// When set, directory listings survive across mounts instead of being
+// rebuilt from a full ObjectsAll listing every time. Empty disables the
+// persistent cache entirely, leaving only the in-memory DirectoryCache.
+var MetaCacheDir string
+
+// MetaCacheTTL bounds how long a persistent listing entry is trusted
+// without being revalidated against swift, regardless of whether the
+// container's validity token still matches. The janitor goroutine
+// started by StartJanitor reclaims anything older.
+var MetaCacheTTL = 24 * time.Hour
+
+var metaCacheBucket = []byte("listings")
+
+// MetaStore is the process-wide persistent cache. It stays nil unless
+// MetaCacheDir is set and OpenMetaCache succeeds, and every consumer
+// must guard on that nil check.
+var MetaStore *PersistentCache
+
+// MetaCacheStats reports a point in time snapshot of persistent cache
+// activity, meant to be exposed over an admin HTTP endpoint.
+type MetaCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Evicted uint64 `json:"evicted"`
+}
+
+// metaCacheNode is the serializable projection of a Node persisted as
+// part of a directory listing entry.
+type metaCacheNode struct {
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	Path         string            `json:"path"`
+	ContentType  string            `json:"content_type"`
+	Bytes        int64             `json:"bytes"`
+	LastModified time.Time         `json:"last_modified"`
+	Headers      map[string]string `json:"headers"`
+	Segmented    bool              `json:"segmented"`
+}
+
+// metaCacheEntry is what gets persisted for one directory listing,
+// keyed by container and path.
+type metaCacheEntry struct {
+	Token    string          `json:"token"`
+	StoredAt time.Time       `json:"stored_at"`
+	Children []metaCacheNode `json:"children"`
+}
+
+// PersistentCache is a BoltDB-backed store of directory listings,
+// consulted by Directory.ReadDirAll before falling back to a swift
+// listing. It is safe for concurrent use.
+type PersistentCache struct {
+	db    *bolt.DB
+	mu    sync.Mutex
+	stats MetaCacheStats
+}
+
+// OpenMetaCache opens (creating if necessary) the persistent metadata
+// cache database under dir.
+func OpenMetaCache(dir string) (*PersistentCache, error) {
+	db, err := bolt.Open(dir+"/meta.db", 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metaCacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &PersistentCache{db: db}, nil
+}
+
+func metaCacheKey(container, path string) []byte {
+	return []byte(container + "\x00" + path)
+}
+
+// Lookup returns the cached children for container/path when an entry
+// exists and its stored token still matches the one swift reports now.
+func (c *PersistentCache) Lookup(container, path, token string) ([]metaCacheNode, bool) {
+	var entry metaCacheEntry
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(metaCacheBucket).Get(metaCacheKey(container, path))
+		if raw == nil {
+			return errMetaCacheMiss
+		}
+		return json.Unmarshal(raw, &entry)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil || entry.Token != token || time.Since(entry.StoredAt) > MetaCacheTTL {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	return entry.Children, true
+}
+
+// Store persists the children of container/path along with the
+// validity token they were fetched under, replacing any prior entry.
+func (c *PersistentCache) Store(container, path, token string, children []metaCacheNode) error {
+	entry := metaCacheEntry{Token: token, StoredAt: time.Now(), Children: children}
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaCacheBucket).Put(metaCacheKey(container, path), raw)
+	})
+}
+
+// Invalidate drops the cached listing for container/path, if any. It
+// is called whenever a directory's content changes through a node
+// other than a full ReadDirAll (Create, Mkdir, Remove, Rename, Symlink).
+func (c *PersistentCache) Invalidate(container, path string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaCacheBucket).Delete(metaCacheKey(container, path))
+	})
+}
+
+// Stats returns a snapshot of cache hit/miss/eviction counters.
+func (c *PersistentCache) Stats() MetaCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	c.db.View(func(tx *bolt.Tx) error {
+		stats.Entries = tx.Bucket(metaCacheBucket).Stats().KeyN
+		return nil
+	})
+
+	return stats
+}
+
+// Close releases the underlying database handle.
+func (c *PersistentCache) Close() error {
+	return c.db.Close()
+}
+
+// StartJanitor runs a background goroutine that periodically drops
+// entries older than MetaCacheTTL, freeing space from directories that
+// are no longer actively mounted or browsed. It runs until stop is
+// closed.
+func (c *PersistentCache) StartJanitor(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.compact()
+			}
+		}
+	}()
+}
+
+func (c *PersistentCache) compact() {
+	var stale [][]byte
+
+	c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaCacheBucket).ForEach(func(k, v []byte) error {
+			var entry metaCacheEntry
+			if err := json.Unmarshal(v, &entry); err != nil || time.Since(entry.StoredAt) > MetaCacheTTL {
+				key := make([]byte, len(k))
+				copy(key, k)
+				stale = append(stale, key)
+			}
+			return nil
+		})
+	})
+
+	if len(stale) == 0 {
+		return
+	}
+
+	c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metaCacheBucket)
+		for _, key := range stale {
+			bucket.Delete(key)
+		}
+		return nil
+	})
+
+	c.mu.Lock()
+	c.stats.Evicted += uint64(len(stale))
+	c.mu.Unlock()
+}
+
+// StatsHandler serves the persistent cache's stats as JSON. It is
+// meant to be registered by the admin HTTP server under a path such as
+// /debug/svfs/metacache.
+func (c *PersistentCache) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Stats())
+}
+
+// invalidateMetaCache drops the persistent listing entry for
+// container/path, if a persistent cache is configured. Every directory
+// mutation that does not go through a full ReadDirAll must call this
+// so a stale entry is never served after Create/Mkdir/Remove/
+// Rename/Symlink.
+func invalidateMetaCache(container, path string) {
+	if MetaStore != nil {
+		MetaStore.Invalidate(container, path)
+	}
+}
+
+// containerValidityToken reports a string that changes whenever
+// container's content changes, derived from the object count and
+// total byte size swift reports for it. Count alone misses an
+// in-place overwrite or truncation that replaces an object without
+// changing how many objects the container holds; folding in Bytes
+// catches those too. Directory listings are only served from the
+// persistent cache while this token is unchanged.
+func containerValidityToken(ctx context.Context, container string) (string, error) {
+	cont, _, err := SwiftConnection.Container(ctx, container)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(cont.Count, 10) + "/" + strconv.FormatInt(cont.Bytes, 10), nil
+}
+
+// toMetaCacheNode projects a Node into its persistable form. ok is
+// false for node types the persistent cache does not know how to
+// rebuild, in which case the whole listing is skipped.
+func toMetaCacheNode(n Node) (node metaCacheNode, ok bool) {
+	switch v := n.(type) {
+	case *Directory:
+		node = metaCacheNode{Name: v.name, Kind: "dir", Path: v.path, Headers: map[string]string(v.sh)}
+		if v.so != nil {
+			node.ContentType = v.so.ContentType
+			node.LastModified = v.so.LastModified
+		}
+		return node, true
+	case *Object:
+		return metaCacheNode{
+			Name:         v.name,
+			Kind:         "object",
+			Path:         v.path,
+			ContentType:  v.so.ContentType,
+			Bytes:        v.so.Bytes,
+			LastModified: v.so.LastModified,
+			Headers:      map[string]string(v.sh),
+			Segmented:    v.segmented,
+		}, true
+	case *Symlink:
+		return metaCacheNode{
+			Name:    v.name,
+			Kind:    "symlink",
+			Path:    v.path,
+			Headers: map[string]string(v.sh),
+		}, true
+	default:
+		return metaCacheNode{}, false
+	}
+}
+
+// hydrate rebuilds the Node this entry describes, attached to parent.
+func (n metaCacheNode) hydrate(parent *Directory) Node {
+	so := &swift.Object{
+		Name:         n.Path,
+		ContentType:  n.ContentType,
+		Bytes:        n.Bytes,
+		LastModified: n.LastModified,
+	}
+	sh := swift.Headers(n.Headers)
+	if sh == nil {
+		sh = swift.Headers{}
+	}
+
+	switch n.Kind {
+	case "dir":
+		return &Directory{name: n.Name, path: n.Path, so: so, sh: sh, c: parent.c, cs: parent.cs}
+	case "symlink":
+		return &Symlink{name: n.Name, path: n.Path, so: so, sh: sh, c: parent.c, p: parent}
+	default:
+		return &Object{name: n.Name, path: n.Path, so: so, sh: sh, c: parent.c, cs: parent.cs, p: parent, segmented: n.Segmented}
+	}
+}
+
+var errMetaCacheMiss = &metaCacheError{"entry not found"}
+
+type metaCacheError struct{ msg string }
+
+func (e *metaCacheError) Error() string { return e.msg }
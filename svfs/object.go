@@ -1,6 +1,9 @@
 package svfs
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"sync"
@@ -12,14 +15,76 @@ import (
 )
 
 const (
-	ManifestHeader      = "X-Object-Manifest"
-	ObjectMetaHeader    = "X-Object-Meta-"
-	ObjectSymlinkHeader = ObjectMetaHeader + "Symlink-Target"
-	ObjectMtimeHeader   = ObjectMetaHeader + "Mtime"
-	ObjectSizeHeader    = ObjectMetaHeader + "Crypto-Origin-Size"
-	ObjectNonceHeader   = ObjectMetaHeader + "Crypto-Nonce"
+	ManifestHeader       = "X-Object-Manifest"
+	StaticManifestHeader = "X-Static-Large-Object"
+	ManifestContentType  = "application/json"
+	ManifestGetQuery     = "multipart-manifest=get"
+	ManifestPutQuery     = "multipart-manifest=put"
+	ManifestDeleteQuery  = "multipart-manifest=delete"
+	ObjectMetaHeader     = "X-Object-Meta-"
+	ObjectSymlinkHeader  = ObjectMetaHeader + "Symlink-Target"
+	ObjectMtimeHeader    = ObjectMetaHeader + "Mtime"
+	ObjectSizeHeader     = ObjectMetaHeader + "Crypto-Origin-Size"
+	ObjectNonceHeader    = ObjectMetaHeader + "Crypto-Nonce"
+	SegmentNoncesHeader  = ObjectMetaHeader + "Crypto-Segment-Nonces"
 )
 
+// LargeObjectMode selects how svfs assembles an object that spans
+// more than one segment.
+type LargeObjectMode string
+
+const (
+	// DLOMode addresses segments through a shared name prefix advertised
+	// by the X-Object-Manifest header. This is the historical svfs
+	// behavior and does not require every segment to exist up front.
+	DLOMode LargeObjectMode = "dlo"
+	// SLOMode addresses segments through an explicit JSON manifest PUT
+	// with ?multipart-manifest=put. Most modern Swift/S3-compatible
+	// providers validate this mode more strictly than DLO.
+	SLOMode LargeObjectMode = "slo"
+)
+
+// SegmentMode is the large object mode used when writing new segmented
+// objects. Existing DLO and SLO objects are read back and removed
+// correctly regardless of this setting.
+var SegmentMode = DLOMode
+
+// SegmentsDirectory is the hidden, per-container prefix segments are
+// written under when InContainerSegments is enabled, instead of a
+// distinct "<container>_segments" container.
+const SegmentsDirectory = ".file-segments"
+
+// InContainerSegments stores new segmented objects under
+// SegmentsDirectory in the same container as their manifest, instead
+// of in a dedicated "<container>_segments" container. Some
+// Swift-compatible providers do not let end users create that extra
+// container, so this is auto-enabled by DetectSegmentStorageMode when
+// creating it comes back forbidden, and can also be forced by the user.
+var InContainerSegments bool
+
+// DetectSegmentStorageMode inspects the error returned while trying to
+// create a container's dedicated segments container and switches to
+// InContainerSegments when the provider forbids it. It is a no-op,
+// returning createErr unchanged, for any other outcome.
+func DetectSegmentStorageMode(createErr error) error {
+	if createErr == swift.Forbidden {
+		InContainerSegments = true
+		return nil
+	}
+	return createErr
+}
+
+// sloSegment describes one member of a Static Large Object manifest,
+// as sent to and read back from swift. ID is only used to reorder
+// segments uploaded out of order by the concurrent upload pool and is
+// never part of the manifest body itself.
+type sloSegment struct {
+	ID        uint   `json:"-"`
+	Path      string `json:"path"`
+	ETag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
 // Object is a node representing a swift object.
 // It belongs to a container and segmented objects
 // are bound to a container of segments.
@@ -34,11 +99,13 @@ type Object struct {
 	m         sync.Mutex
 	segmented bool
 	writing   bool
+	slo       []sloSegment
+	lastSize  uint64
 }
 
 // Attr fills the file attributes for an object node.
 func (o *Object) Attr(ctx context.Context, a *fuse.Attr) (err error) {
-	a.Size = o.size()
+	a.Size = o.size(ctx)
 	a.BlockSize = uint32(BlockSize)
 	a.Blocks = (a.Size / uint64(a.BlockSize)) * 8
 	a.Mode = os.FileMode(DefaultMode)
@@ -58,16 +125,17 @@ func (o *Object) Export() fuse.Dirent {
 	}
 }
 
-func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (oh *ObjectHandle, err error) {
+func (o *Object) open(ctx context.Context, mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (oh *ObjectHandle, err error) {
 	oh = &ObjectHandle{
 		target: o,
 		create: mode&fuse.OpenCreate == fuse.OpenCreate,
 	}
-
-	// Append mode is not supported
-	if mode&fuse.OpenAppend == fuse.OpenAppend {
-		return nil, fuse.ENOTSUP
-	}
+	// The handle can outlive this single FUSE call, in particular while
+	// segment uploads are still draining on Release, so it gets its own
+	// cancelable context derived from RootContext rather than the
+	// request's own ctx, which bazil/fuse cancels as soon as Open
+	// returns.
+	oh.ctx, oh.cancel = context.WithCancel(RootContext)
 
 	if mode.IsReadOnly() {
 		return oh, nil
@@ -81,16 +149,26 @@ func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (oh *O
 		// Don't cache writes
 		*flags |= fuse.OpenDirectIO
 
+		// Appending writes new segments after whatever the object
+		// already holds, instead of truncating it like a plain open
+		// for write does.
+		if mode&fuse.OpenAppend == fuse.OpenAppend && !oh.create {
+			if err = oh.prepareAppend(ctx); err != nil {
+				return oh, err
+			}
+			return oh, nil
+		}
+
 		// Remove segments
 		if o.segmented && oh.create {
-			if err = o.removeSegments(); err != nil {
+			if err = o.removeSegments(ctx); err != nil {
 				return oh, err
 			}
 		}
 
 		// Create new object
 		if oh.create {
-			oh.wd, err = newWriter(oh.target.c.Name, oh.target.path, &oh.nonce)
+			oh.wd, err = newWriter(ctx, oh.target.c.Name, oh.target.path, &oh.nonce)
 		}
 
 		return oh, err
@@ -101,7 +179,7 @@ func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (oh *O
 
 // Open returns the file handle associated with this object node.
 func (o *Object) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	return o.open(req.Flags, &resp.Flags)
+	return o.open(ctx, req.Flags, &resp.Flags)
 }
 
 func (o *Object) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
@@ -111,7 +189,7 @@ func (o *Object) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 	if req.Valid.Size() {
 		o.so.Bytes = int64(req.Size)
 		if req.Size == 0 && o.segmented {
-			return o.removeSegments()
+			return o.removeSegments(ctx)
 		}
 		return nil
 	}
@@ -129,7 +207,7 @@ func (o *Object) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 		h := o.sh.ObjectMetadata().Headers(ObjectMetaHeader)
 		o.sh[ObjectMtimeHeader] = swift.TimeToFloatString(req.Mtime)
 		h[ObjectMtimeHeader] = o.sh[ObjectMtimeHeader]
-		return SwiftConnection.ObjectUpdate(o.c.Name, o.so.Name, h)
+		return SwiftConnection.ObjectUpdate(ctx, o.c.Name, o.so.Name, h)
 	}
 
 	return nil
@@ -140,16 +218,71 @@ func (o *Object) Name() string {
 	return o.name
 }
 
-func (o *Object) removeSegments() error {
+// isStaticLargeObject reports whether this object is addressed as a
+// Static Large Object rather than a Dynamic Large Object, i.e. it was
+// uploaded as application/json with X-Static-Large-Object: true.
+func (o *Object) isStaticLargeObject() bool {
+	return o.so.ContentType == ManifestContentType && o.sh[StaticManifestHeader] == "true"
+}
+
+// loadSLOManifest fetches and decodes the Static Large Object manifest
+// body for this object, caching segment sizes and etags so size() does
+// not need to hit swift again.
+func (o *Object) loadSLOManifest(ctx context.Context) error {
+	var buf bytes.Buffer
+	_, err := SwiftConnection.ObjectGet(ctx, o.c.Name, o.path+"?"+ManifestGetQuery, &buf, true, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), &o.slo)
+}
+
+func (o *Object) removeSegments(ctx context.Context) error {
 	o.segmented = false
-	if err := deleteSegments(o.cs.Name, o.sh[ManifestHeader]); err != nil {
+	if o.isStaticLargeObject() {
+		err := SwiftConnection.ObjectDelete(ctx, o.c.Name, o.path+"?"+ManifestDeleteQuery)
+		o.slo = nil
+		delete(o.sh, StaticManifestHeader)
+		return err
+	}
+	// The manifest header always spells out "container/prefix", and
+	// that container is the one segments actually live in, whether
+	// that's o.cs (a dedicated "<container>_segments" container) or
+	// o.c itself (InContainerSegments' hidden .file-segments/ prefix).
+	// Trusting o.cs unconditionally looks in the wrong place whenever
+	// InContainerSegments is set.
+	manifest := o.sh[ManifestHeader]
+	parts := SegmentPathRegex.FindStringSubmatch(manifest)
+	if parts == nil {
+		return fmt.Errorf("invalid segment path for manifest %s", o.path)
+	}
+	if err := deleteSegments(ctx, parts[1], manifest); err != nil {
 		return err
 	}
 	delete(o.sh, ManifestHeader)
 	return nil
 }
 
-func (o *Object) size() uint64 {
+func (o *Object) size(ctx context.Context) uint64 {
+	if o.isStaticLargeObject() {
+		if o.slo == nil {
+			if err := o.loadSLOManifest(ctx); err != nil {
+				// A failed manifest GET (network blip, throttling, auth
+				// hiccup) must not be reported as an empty file: that's
+				// far more disruptive to whatever is stat-ing it than a
+				// stale size. Fall back to the last one we computed
+				// successfully, if any, and retry the GET next time
+				// since o.slo is still nil.
+				return o.lastSize
+			}
+		}
+		var size int64
+		for _, seg := range o.slo {
+			size += seg.SizeBytes
+		}
+		o.lastSize = uint64(size)
+		return o.lastSize
+	}
 	if Encryption && o.sh[ObjectSizeHeader] != "" {
 		size, _ := strconv.ParseInt(o.sh[ObjectSizeHeader], 10, 64)
 		return uint64(size)